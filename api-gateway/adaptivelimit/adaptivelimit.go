@@ -0,0 +1,164 @@
+// Package adaptivelimit admits requests to an upstream under a dynamic
+// concurrency limit instead of a fixed per-IP quota, so the gateway sheds
+// load as an upstream's latency degrades rather than waiting for its
+// circuit breaker to trip.
+package adaptivelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	limitGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "adaptive_limit_current",
+			Help: "Current AIMD concurrency limit for each upstream",
+		},
+		[]string{"name"},
+	)
+
+	inflightGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "adaptive_limit_inflight",
+			Help: "Number of in-flight requests currently admitted for each upstream",
+		},
+		[]string{"name"},
+	)
+)
+
+// Settings configures a Controller's AIMD behavior.
+type Settings struct {
+	// Name identifies the upstream for metrics.
+	Name string
+
+	// MinLimit and MaxLimit bound the concurrency limit.
+	MinLimit int
+	MaxLimit int
+
+	// InitialLimit is the limit a new Controller starts at.
+	InitialLimit int
+
+	// Beta is the multiplicative-decrease factor applied to the limit
+	// when latency degrades or the breaker is open, e.g. 0.9.
+	Beta float64
+
+	// IncreaseEvery is the number of consecutive healthy requests (latency
+	// at or below the baseline, breaker closed) required before the limit
+	// is additively incremented by one.
+	IncreaseEvery int
+
+	// BaselineAlpha is the EWMA smoothing factor used to track the rolling
+	// latency baseline: baseline = alpha*latest + (1-alpha)*baseline.
+	BaselineAlpha float64
+
+	// LatencyMultiplier (k) triggers a decrease when a request's latency
+	// exceeds k times the rolling baseline.
+	LatencyMultiplier float64
+}
+
+// Controller admits requests to a single upstream under a concurrency limit
+// that grows additively while latency stays near its rolling baseline and
+// shrinks multiplicatively when latency spikes or the upstream's circuit
+// breaker is open.
+type Controller struct {
+	mu       sync.Mutex
+	name     string
+	settings Settings
+
+	limit     int
+	inflight  int
+	baseline  time.Duration
+	goodCount int
+}
+
+// NewController creates a Controller from settings, starting at
+// settings.InitialLimit.
+func NewController(settings Settings) *Controller {
+	c := &Controller{
+		name:     settings.Name,
+		settings: settings,
+		limit:    settings.InitialLimit,
+	}
+	limitGauge.WithLabelValues(c.name).Set(float64(c.limit))
+	return c
+}
+
+// AllowRequest reports whether another request may be admitted without
+// exceeding the current limit, incrementing the in-flight count if so. Every
+// admitted request must eventually call Release.
+func (c *Controller) AllowRequest() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.inflight >= c.limit {
+		return false
+	}
+	c.inflight++
+	inflightGauge.WithLabelValues(c.name).Set(float64(c.inflight))
+	return true
+}
+
+// Release returns an in-flight slot admitted by AllowRequest.
+func (c *Controller) Release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.inflight > 0 {
+		c.inflight--
+	}
+	inflightGauge.WithLabelValues(c.name).Set(float64(c.inflight))
+}
+
+// RecordLatency feeds a completed request's latency into the AIMD
+// controller. breakerOpen should reflect whether the upstream's circuit
+// breaker was open for this request; an open breaker always triggers the
+// multiplicative decrease regardless of latency.
+func (c *Controller) RecordLatency(latency time.Duration, breakerOpen bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	degraded := breakerOpen
+	if !degraded && c.baseline > 0 && float64(latency) > c.settings.LatencyMultiplier*float64(c.baseline) {
+		degraded = true
+	}
+	healthy := !degraded && (c.baseline == 0 || latency <= c.baseline)
+
+	if c.baseline == 0 {
+		c.baseline = latency
+	} else {
+		c.baseline = time.Duration(c.settings.BaselineAlpha*float64(latency) + (1-c.settings.BaselineAlpha)*float64(c.baseline))
+	}
+
+	if degraded {
+		c.goodCount = 0
+		if newLimit := int(float64(c.limit) * c.settings.Beta); newLimit < c.limit {
+			c.limit = newLimit
+		}
+		if c.limit < c.settings.MinLimit {
+			c.limit = c.settings.MinLimit
+		}
+	} else if healthy {
+		c.goodCount++
+		if c.goodCount >= c.settings.IncreaseEvery {
+			c.goodCount = 0
+			if c.limit < c.settings.MaxLimit {
+				c.limit++
+			}
+		}
+	} else {
+		c.goodCount = 0
+	}
+
+	limitGauge.WithLabelValues(c.name).Set(float64(c.limit))
+}
+
+// Limit returns the controller's current concurrency limit.
+func (c *Controller) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limit
+}
@@ -0,0 +1,39 @@
+package adaptivelimit
+
+import "sync"
+
+// Registry lazily constructs and caches one Controller per name, so each
+// upstream/route gets its own isolated concurrency limit instead of sharing
+// one global semaphore. Controllers are built from whatever Settings the
+// configured factory returns for that name, on first access.
+type Registry struct {
+	mu          sync.Mutex
+	factory     func(name string) Settings
+	controllers map[string]*Controller
+}
+
+// NewRegistry creates a Registry that builds a controller's Settings on
+// demand via factory. factory is called at most once per distinct name.
+func NewRegistry(factory func(name string) Settings) *Registry {
+	return &Registry{
+		factory:     factory,
+		controllers: make(map[string]*Controller),
+	}
+}
+
+// Get returns the controller registered under name, constructing it from
+// the registry's factory on first access.
+func (r *Registry) Get(name string) *Controller {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.controllers[name]; ok {
+		return c
+	}
+
+	settings := r.factory(name)
+	settings.Name = name
+	c := NewController(settings)
+	r.controllers[name] = c
+	return c
+}
@@ -1,7 +1,7 @@
 package circuitbreaker
 
 import (
-	"fmt"
+	"context"
 	"sync"
 	"time"
 )
@@ -15,29 +15,216 @@ const (
 	StateHalfOpen
 )
 
-// CircuitBreaker implements the circuit breaker pattern
+func (s CircuitBreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// defaultWindow and defaultBuckets back the legacy NewCircuitBreaker
+// constructor with a single bucket spanning a full day, which makes the
+// rolling window effectively lifetime-cumulative for callers that don't
+// care about bucketing.
+const (
+	defaultWindow              = 24 * time.Hour
+	defaultBuckets             = 1
+	defaultMaxHalfOpenRequests = 1
+)
+
+// bucket holds the success/failure counters for a single slice of the
+// rolling window. Timestamp records the last time the bucket was written
+// to, which is what makes a bucket eligible for expiry.
+type bucket struct {
+	success   int
+	failure   int
+	timestamp time.Time
+}
+
+// BucketStats is the exported, read-only view of a single bucket returned
+// by Counts().
+type BucketStats struct {
+	Success   int
+	Failure   int
+	Timestamp time.Time
+}
+
+// Counts is a point-in-time snapshot of the circuit breaker's rolling
+// window, suitable for ReadyToTrip predicates, tests and metrics exporters.
+type Counts struct {
+	Buckets     []BucketStats
+	Successes   int
+	Failures    int
+	Total       int
+	SuccessRate float64
+}
+
+// Settings configures a CircuitBreaker created via NewCircuitBreakerFromSettings
+// or lazily by a Registry. It mirrors the shape of sony/gobreaker's Settings
+// so trip conditions can be expressed as a predicate over Counts rather than
+// a single hard-coded rule.
+type Settings struct {
+	// Name identifies the breaker, e.g. for logging and metrics.
+	Name string
+
+	// ResetTimeout is how long the breaker stays OPEN before allowing a
+	// trial request through in HALF-OPEN.
+	ResetTimeout time.Duration
+
+	// Window and Buckets configure the rolling window the breaker tracks
+	// statistics over. They default to a single 24h bucket (effectively
+	// lifetime-cumulative) if left zero.
+	Window  time.Duration
+	Buckets int
+
+	// RequiredConsecutiveSuccesses is how many consecutive successes a
+	// HALF-OPEN breaker needs before it closes again. Defaults to 1.
+	RequiredConsecutiveSuccesses int
+
+	// MaxHalfOpenRequests bounds how many trial requests are allowed
+	// through concurrently while the breaker is HALF-OPEN. Defaults to 1.
+	MaxHalfOpenRequests int
+
+	// ReadyToTrip is evaluated against the current windowed Counts after
+	// every recorded result while the breaker is CLOSED; returning true
+	// opens the breaker. Defaults to tripping after 5 failures in the
+	// window, matching gobreaker's default.
+	ReadyToTrip func(Counts) bool
+
+	// OnStateChange, if set, is invoked whenever the breaker transitions
+	// from one state to another.
+	OnStateChange func(name string, from, to CircuitBreakerState)
+
+	// IsSuccessful classifies an error returned by Execute's req func as a
+	// breaker success or failure, e.g. so a 4xx upstream response wrapped
+	// in an error can be excluded from the failure ratio. Defaults to
+	// treating every non-nil error as a failure.
+	IsSuccessful func(err error) bool
+
+	// Fallback, if set, is invoked by Execute instead of returning
+	// ErrCircuitOpen when the breaker denies a request.
+	Fallback func(ctx context.Context, err error) (interface{}, error)
+}
+
+// CircuitBreaker implements the circuit breaker pattern with a sliding
+// window of failure/success statistics instead of lifetime totals, so a
+// breaker that has been healthy for a long time isn't tripped by a
+// historical burst that has since rolled out of the window.
 type CircuitBreaker struct {
-	mu               sync.RWMutex
-	state            CircuitBreakerState
-	failureCount     int
+	mu              sync.RWMutex
+	name            string
+	state           CircuitBreakerState
+	resetTimeout    time.Duration
+	lastFailureTime time.Time
+
+	window         time.Duration
+	bucketDuration time.Duration
+	buckets        []bucket
+
+	// requiredConsecutiveSuccesses is how many consecutive successes a
+	// half-open breaker needs before it closes again.
+	requiredConsecutiveSuccesses int
+	consecutiveSuccesses         int
+
+	maxHalfOpenRequests int
+	halfOpenInFlight    int
+
+	readyToTrip   func(Counts) bool
+	onStateChange func(name string, from, to CircuitBreakerState)
+	isSuccessful  func(err error) bool
+	fallback      func(ctx context.Context, err error) (interface{}, error)
+
+	// failureThreshold and successRate back the legacy constructors'
+	// built-in trip rule: open once failures in the window reach
+	// failureThreshold, or once the success rate in the window drops
+	// below successRate.
 	failureThreshold int
-	resetTimeout     time.Duration
-	lastFailureTime  time.Time
-	successCount     int
-	totalCount       int
 	successRate      float64
 }
 
-// NewCircuitBreaker creates a new circuit breaker instance
+// NewCircuitBreaker creates a new circuit breaker instance. It behaves like
+// a lifetime-cumulative breaker (a single bucket covering a 24h window) and
+// closes after a single success in half-open, matching the original
+// behavior of this package. Callers that want an actual rolling window
+// should use NewCircuitBreakerWithWindow; callers that want a custom trip
+// predicate or per-route isolation should use NewCircuitBreakerFromSettings
+// or a Registry.
 func NewCircuitBreaker(failureThreshold int, resetTimeout time.Duration, successRate float64) *CircuitBreaker {
+	return NewCircuitBreakerWithWindow(failureThreshold, resetTimeout, successRate, defaultWindow, defaultBuckets, 1)
+}
+
+// NewCircuitBreakerWithWindow creates a circuit breaker whose failure and
+// success counts are tracked over a rolling window of the given duration,
+// split into numBuckets equally sized buckets (e.g. a 10s window across 10
+// 1s buckets). requiredConsecutiveSuccesses is how many consecutive
+// successful requests a half-open breaker must see before it closes; it is
+// clamped to at least 1.
+func NewCircuitBreakerWithWindow(failureThreshold int, resetTimeout time.Duration, successRate float64, window time.Duration, numBuckets int, requiredConsecutiveSuccesses int) *CircuitBreaker {
+	cb := NewCircuitBreakerFromSettings(Settings{
+		ResetTimeout:                 resetTimeout,
+		Window:                       window,
+		Buckets:                      numBuckets,
+		RequiredConsecutiveSuccesses: requiredConsecutiveSuccesses,
+	})
+	cb.failureThreshold = failureThreshold
+	cb.successRate = successRate
+	cb.readyToTrip = nil
+	return cb
+}
+
+// NewCircuitBreakerFromSettings creates a circuit breaker from an explicit
+// Settings struct, applying the same defaults a zero-value Settings{} would
+// get from a Registry.
+func NewCircuitBreakerFromSettings(settings Settings) *CircuitBreaker {
+	window := settings.Window
+	if window <= 0 {
+		window = defaultWindow
+	}
+	numBuckets := settings.Buckets
+	if numBuckets < 1 {
+		numBuckets = defaultBuckets
+	}
+	requiredConsecutiveSuccesses := settings.RequiredConsecutiveSuccesses
+	if requiredConsecutiveSuccesses < 1 {
+		requiredConsecutiveSuccesses = 1
+	}
+	maxHalfOpenRequests := settings.MaxHalfOpenRequests
+	if maxHalfOpenRequests < 1 {
+		maxHalfOpenRequests = defaultMaxHalfOpenRequests
+	}
+	readyToTrip := settings.ReadyToTrip
+	if readyToTrip == nil {
+		readyToTrip = func(c Counts) bool { return c.Failures >= 5 }
+	}
+
 	return &CircuitBreaker{
-		state:            StateClosed,
-		failureThreshold: failureThreshold,
-		resetTimeout:     resetTimeout,
-		successRate:      successRate,
+		name:                         settings.Name,
+		state:                        StateClosed,
+		resetTimeout:                 settings.ResetTimeout,
+		window:                       window,
+		bucketDuration:               window / time.Duration(numBuckets),
+		buckets:                      make([]bucket, numBuckets),
+		requiredConsecutiveSuccesses: requiredConsecutiveSuccesses,
+		maxHalfOpenRequests:          maxHalfOpenRequests,
+		readyToTrip:                  readyToTrip,
+		onStateChange:                settings.OnStateChange,
+		isSuccessful:                 settings.IsSuccessful,
+		fallback:                     settings.Fallback,
 	}
 }
 
+// Name returns the breaker's configured name, or "" if it was constructed
+// without one.
+func (cb *CircuitBreaker) Name() string {
+	return cb.name
+}
+
 // GetState returns the current state of the circuit breaker
 func (cb *CircuitBreaker) GetState() CircuitBreakerState {
 	cb.mu.RLock()
@@ -45,77 +232,239 @@ func (cb *CircuitBreaker) GetState() CircuitBreakerState {
 	return cb.state
 }
 
-// RecordFailure records a failure and updates the circuit breaker state
+// Counts returns a snapshot of the rolling window's per-bucket and
+// aggregate statistics.
+func (cb *CircuitBreaker) Counts() Counts {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	return cb.snapshot(time.Now())
+}
+
+// bucketIndex returns which bucket slot `now` falls into.
+func (cb *CircuitBreaker) bucketIndex(now time.Time) int {
+	slot := now.UnixNano() / int64(cb.bucketDuration)
+	return int(slot % int64(len(cb.buckets)))
+}
+
+// expired reports whether a bucket's contents are outside the rolling
+// window as of `now`.
+func (cb *CircuitBreaker) expired(b bucket, now time.Time) bool {
+	return b.timestamp.IsZero() || now.Sub(b.timestamp) >= cb.window
+}
+
+// advance zeroes out any bucket that has aged out of the window and
+// returns a pointer to the bucket for `now`, ready to record into.
+func (cb *CircuitBreaker) advance(now time.Time) *bucket {
+	for i := range cb.buckets {
+		if cb.expired(cb.buckets[i], now) {
+			cb.buckets[i] = bucket{}
+		}
+	}
+	b := &cb.buckets[cb.bucketIndex(now)]
+	// The slot may still hold counts from an earlier pass around the ring
+	// whose last write is less than a window old; start it fresh.
+	bucketNanos := int64(cb.bucketDuration)
+	if !b.timestamp.IsZero() && b.timestamp.UnixNano()/bucketNanos != now.UnixNano()/bucketNanos {
+		*b = bucket{}
+	}
+	b.timestamp = now
+	return b
+}
+
+// currentCounts sums only the non-expired buckets in the rolling window.
+func (cb *CircuitBreaker) currentCounts(now time.Time) (successes, failures, total int) {
+	for _, b := range cb.buckets {
+		if cb.expired(b, now) {
+			continue
+		}
+		successes += b.success
+		failures += b.failure
+	}
+	total = successes + failures
+	return successes, failures, total
+}
+
+func (cb *CircuitBreaker) snapshot(now time.Time) Counts {
+	successes, failures, total := cb.currentCounts(now)
+	buckets := make([]BucketStats, len(cb.buckets))
+	for i, b := range cb.buckets {
+		buckets[i] = BucketStats{Success: b.success, Failure: b.failure, Timestamp: b.timestamp}
+	}
+
+	counts := Counts{
+		Buckets:   buckets,
+		Successes: successes,
+		Failures:  failures,
+		Total:     total,
+	}
+	if total > 0 {
+		counts.SuccessRate = float64(successes) / float64(total)
+	}
+	return counts
+}
+
+// setState transitions the breaker to `to`, firing OnStateChange if it
+// actually changed. Callers must hold cb.mu.
+func (cb *CircuitBreaker) setState(to CircuitBreakerState) {
+	if cb.state == to {
+		return
+	}
+	from := cb.state
+	cb.state = to
+	cb.consecutiveSuccesses = 0
+	cb.halfOpenInFlight = 0
+	if cb.onStateChange != nil {
+		cb.onStateChange(cb.name, from, to)
+	}
+}
+
+// shouldTripOnFailure and shouldTripOnSuccess evaluate the breaker's trip
+// condition after recording a failure or success respectively: the custom
+// ReadyToTrip predicate if one was configured, otherwise the legacy
+// failureThreshold/successRate rule used by NewCircuitBreaker, which only
+// ever checks the failure count on a failure and the success rate on a
+// success.
+func (cb *CircuitBreaker) shouldTripOnFailure(now time.Time) bool {
+	if cb.readyToTrip != nil {
+		return cb.readyToTrip(cb.snapshot(now))
+	}
+	_, failures, _ := cb.currentCounts(now)
+	return cb.failureThreshold > 0 && failures >= cb.failureThreshold
+}
+
+func (cb *CircuitBreaker) shouldTripOnSuccess(now time.Time) bool {
+	if cb.readyToTrip != nil {
+		return cb.readyToTrip(cb.snapshot(now))
+	}
+	successes, _, total := cb.currentCounts(now)
+	return cb.successRate > 0 && total > 0 && float64(successes)/float64(total) < cb.successRate
+}
+
+// RecordFailure records a failure in the current bucket and updates the
+// circuit breaker state based on the windowed counts.
 func (cb *CircuitBreaker) RecordFailure() {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
-	cb.failureCount++
-	cb.totalCount++
-	cb.lastFailureTime = time.Now()
+	now := time.Now()
+	b := cb.advance(now)
+	b.failure++
+	cb.lastFailureTime = now
 
-	if cb.state == StateClosed {
-		if cb.failureCount >= cb.failureThreshold {
-			cb.state = StateOpen
+	switch cb.state {
+	case StateHalfOpen:
+		cb.setState(StateOpen)
+	case StateClosed:
+		if cb.shouldTripOnFailure(now) {
+			cb.setState(StateOpen)
 		}
-	} else if cb.state == StateHalfOpen {
-		cb.state = StateOpen
 	}
 }
 
-// RecordSuccess records a success and updates the circuit breaker state
+// RecordSuccess records a success in the current bucket and updates the
+// circuit breaker state based on the windowed counts.
 func (cb *CircuitBreaker) RecordSuccess() {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
-	fmt.Printf("[DEBUG] RecordSuccess called. State before: %v\n", cb.state)
-	cb.successCount++
-	cb.totalCount++
-
-	if cb.state == StateHalfOpen {
-		fmt.Println("[DEBUG] Transitioning from HALF-OPEN to CLOSED")
-		cb.state = StateClosed
-		cb.failureCount = 0
-		cb.successCount = 0 // Reset success count when transitioning to CLOSED
-		cb.totalCount = 0   // Reset total count when transitioning to CLOSED
-		return              // Return immediately after transitioning to CLOSED
-	}
-
-	// Only check success rate if we're in CLOSED state
-	if cb.state == StateClosed && cb.totalCount > 0 {
-		currentSuccessRate := float64(cb.successCount) / float64(cb.totalCount)
-		fmt.Printf("[DEBUG] Current success rate: %.2f\n", currentSuccessRate)
-		if currentSuccessRate < cb.successRate {
-			fmt.Println("[DEBUG] Success rate below threshold, transitioning to OPEN")
-			cb.state = StateOpen
+	now := time.Now()
+	b := cb.advance(now)
+	b.success++
+
+	switch cb.state {
+	case StateHalfOpen:
+		cb.consecutiveSuccesses++
+		if cb.consecutiveSuccesses >= cb.requiredConsecutiveSuccesses {
+			cb.buckets = make([]bucket, len(cb.buckets))
+			cb.setState(StateClosed)
+		} else {
+			// Still HALF-OPEN: setState won't fire (no state change), so
+			// release this trial slot here instead, or halfOpenInFlight
+			// would never drop and AllowRequest would wedge shut forever.
+			cb.halfOpenInFlight--
+		}
+	case StateClosed:
+		if cb.shouldTripOnSuccess(now) {
+			cb.setState(StateOpen)
 		}
 	}
-	fmt.Printf("[DEBUG] RecordSuccess finished. State after: %v\n", cb.state)
 }
 
-// AllowRequest determines if a request should be allowed based on the current state
+// abandonHalfOpenTrial releases a half-open trial slot that AllowRequest
+// handed out without recording a success or failure, for a caller (Execute)
+// that deliberately skips accounting for the outcome entirely. Without this,
+// a client-canceled request during a half-open trial would leak the slot
+// forever, since neither RecordSuccess nor RecordFailure ever runs for it.
+func (cb *CircuitBreaker) abandonHalfOpenTrial() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == StateHalfOpen && cb.halfOpenInFlight > 0 {
+		cb.halfOpenInFlight--
+	}
+}
+
+// AllowRequest determines if a request should be allowed based on the
+// current state. In HALF-OPEN it admits at most MaxHalfOpenRequests
+// concurrent trial requests.
 func (cb *CircuitBreaker) AllowRequest() bool {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
-	fmt.Printf("[DEBUG] AllowRequest called. State: %v\n", cb.state)
 	switch cb.state {
 	case StateClosed:
-		fmt.Println("[DEBUG] State is CLOSED, allowing request.")
 		return true
 	case StateOpen:
 		if time.Since(cb.lastFailureTime) >= cb.resetTimeout {
-			fmt.Println("[DEBUG] Timeout passed, transitioning to HALF-OPEN.")
-			cb.state = StateHalfOpen
+			cb.setState(StateHalfOpen)
+			cb.halfOpenInFlight = 1
 			return true
 		}
-		fmt.Println("[DEBUG] State is OPEN, request not allowed.")
 		return false
 	case StateHalfOpen:
-		fmt.Println("[DEBUG] State is HALF-OPEN, allowing request.")
+		if cb.halfOpenInFlight >= cb.maxHalfOpenRequests {
+			return false
+		}
+		cb.halfOpenInFlight++
 		return true
 	default:
-		fmt.Println("[DEBUG] Unknown state, request not allowed.")
 		return false
 	}
 }
+
+// Execute runs req under the breaker's protection: it denies the call with
+// ErrCircuitOpen (or routes it to Fallback, if configured) when the breaker
+// is open, otherwise it runs req with the caller's context and records the
+// outcome.
+//
+// A context.Canceled error is treated as a client-side abort and is not
+// recorded as a breaker failure at all; if req ran as a half-open trial,
+// its slot is released anyway so a canceled request can't wedge the
+// breaker shut. Any other error is classified via IsSuccessful, if
+// configured; a nil IsSuccessful treats every non-nil error, including
+// context.DeadlineExceeded, as a failure.
+func (cb *CircuitBreaker) Execute(ctx context.Context, req func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if !cb.AllowRequest() {
+		if cb.fallback != nil {
+			return cb.fallback(ctx, &ErrCircuitOpen{Name: cb.name})
+		}
+		return nil, &ErrCircuitOpen{Name: cb.name}
+	}
+
+	result, err := req(ctx)
+	if err == nil {
+		cb.RecordSuccess()
+		return result, nil
+	}
+
+	if ctx.Err() == context.Canceled {
+		cb.abandonHalfOpenTrial()
+		return result, err
+	}
+
+	if cb.isSuccessful != nil && cb.isSuccessful(err) {
+		cb.RecordSuccess()
+	} else {
+		cb.RecordFailure()
+	}
+	return result, err
+}
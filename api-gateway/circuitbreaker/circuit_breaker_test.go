@@ -1,6 +1,8 @@
 package circuitbreaker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -73,3 +75,207 @@ func TestCircuitBreakerSuccessRate(t *testing.T) {
 		t.Errorf("Expected state to be OPEN with 40%% success rate, got %v", cb.GetState())
 	}
 }
+
+func TestCircuitBreakerRollingWindowExpiry(t *testing.T) {
+	cb := NewCircuitBreakerWithWindow(2, 100*time.Millisecond, 0, 50*time.Millisecond, 5, 1)
+
+	cb.RecordFailure()
+	time.Sleep(60 * time.Millisecond)
+	cb.RecordFailure()
+	if cb.GetState() != StateClosed {
+		t.Errorf("Expected state to be CLOSED once the first failure rolled out of the window, got %v", cb.GetState())
+	}
+
+	counts := cb.Counts()
+	if counts.Failures != 1 {
+		t.Errorf("Expected 1 non-expired failure in the window, got %d", counts.Failures)
+	}
+}
+
+func TestCircuitBreakerCounts(t *testing.T) {
+	cb := NewCircuitBreaker(5, 100*time.Millisecond, 0)
+
+	cb.RecordSuccess()
+	cb.RecordSuccess()
+	cb.RecordFailure()
+
+	counts := cb.Counts()
+	if counts.Successes != 2 || counts.Failures != 1 || counts.Total != 3 {
+		t.Errorf("Expected Counts{Successes:2,Failures:1,Total:3}, got %+v", counts)
+	}
+	if counts.SuccessRate != 2.0/3.0 {
+		t.Errorf("Expected SuccessRate 2/3, got %v", counts.SuccessRate)
+	}
+	if len(counts.Buckets) != defaultBuckets {
+		t.Errorf("Expected %d bucket(s), got %d", defaultBuckets, len(counts.Buckets))
+	}
+}
+
+// TestCircuitBreakerRequiredConsecutiveSuccesses reproduces the wedge where a
+// HALF-OPEN breaker that needs more than one consecutive success to close
+// never releases its half-open trial slot after the first success, which
+// makes every later AllowRequest() call return false forever.
+func TestCircuitBreakerRequiredConsecutiveSuccesses(t *testing.T) {
+	cb := NewCircuitBreakerWithWindow(1, 10*time.Millisecond, 0, 24*time.Hour, 1, 2)
+
+	cb.RecordFailure()
+	if cb.GetState() != StateOpen {
+		t.Fatalf("Expected state to be OPEN after 1 failure, got %v", cb.GetState())
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if !cb.AllowRequest() {
+		t.Fatal("Expected first trial request to be allowed in HALF-OPEN")
+	}
+	cb.RecordSuccess()
+	if cb.GetState() != StateHalfOpen {
+		t.Fatalf("Expected state to still be HALF-OPEN after 1 of 2 required successes, got %v", cb.GetState())
+	}
+
+	if !cb.AllowRequest() {
+		t.Fatal("Expected second trial request to be allowed after the first half-open slot was released")
+	}
+	cb.RecordSuccess()
+	if cb.GetState() != StateClosed {
+		t.Errorf("Expected state to be CLOSED after 2 consecutive successes, got %v", cb.GetState())
+	}
+}
+
+func TestCircuitBreakerMaxHalfOpenRequests(t *testing.T) {
+	cb := NewCircuitBreakerFromSettings(Settings{
+		ResetTimeout:        10 * time.Millisecond,
+		MaxHalfOpenRequests: 2,
+		ReadyToTrip:         func(c Counts) bool { return c.Failures >= 1 },
+	})
+
+	cb.RecordFailure()
+	time.Sleep(20 * time.Millisecond)
+
+	if !cb.AllowRequest() {
+		t.Fatal("Expected 1st half-open trial to be allowed")
+	}
+	if !cb.AllowRequest() {
+		t.Fatal("Expected 2nd half-open trial to be allowed (MaxHalfOpenRequests=2)")
+	}
+	if cb.AllowRequest() {
+		t.Error("Expected 3rd half-open trial to be denied once both slots are in flight")
+	}
+}
+
+func TestCircuitBreakerExecute(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		cb := NewCircuitBreaker(3, 100*time.Millisecond, 0)
+		result, err := cb.Execute(context.Background(), func(ctx context.Context) (interface{}, error) {
+			return "ok", nil
+		})
+		if err != nil || result != "ok" {
+			t.Errorf("Expected (\"ok\", nil), got (%v, %v)", result, err)
+		}
+		if counts := cb.Counts(); counts.Successes != 1 {
+			t.Errorf("Expected RecordSuccess to run, got Successes=%d", counts.Successes)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		cb := NewCircuitBreaker(3, 100*time.Millisecond, 0)
+		boom := errors.New("boom")
+		_, err := cb.Execute(context.Background(), func(ctx context.Context) (interface{}, error) {
+			return nil, boom
+		})
+		if !errors.Is(err, boom) {
+			t.Errorf("Expected Execute to return the req error, got %v", err)
+		}
+		if counts := cb.Counts(); counts.Failures != 1 {
+			t.Errorf("Expected RecordFailure to run, got Failures=%d", counts.Failures)
+		}
+	})
+
+	t.Run("isSuccessfulClassifiesError", func(t *testing.T) {
+		cb := NewCircuitBreakerFromSettings(Settings{
+			ResetTimeout: 100 * time.Millisecond,
+			ReadyToTrip:  func(c Counts) bool { return c.Failures >= 1 },
+			IsSuccessful: func(err error) bool { return err.Error() == "not found" },
+		})
+		_, err := cb.Execute(context.Background(), func(ctx context.Context) (interface{}, error) {
+			return nil, errors.New("not found")
+		})
+		if err == nil {
+			t.Fatal("Expected Execute to return the req error")
+		}
+		if cb.GetState() != StateClosed {
+			t.Errorf("Expected IsSuccessful to classify the error as a success, got state %v", cb.GetState())
+		}
+	})
+
+	t.Run("circuitOpenReturnsErrCircuitOpen", func(t *testing.T) {
+		cb := NewCircuitBreakerFromSettings(Settings{
+			Name:         "cache",
+			ResetTimeout: time.Hour,
+			ReadyToTrip:  func(c Counts) bool { return c.Failures >= 1 },
+		})
+		cb.RecordFailure()
+
+		_, err := cb.Execute(context.Background(), func(ctx context.Context) (interface{}, error) {
+			t.Fatal("req should not run while the breaker is open")
+			return nil, nil
+		})
+		var circuitOpen *ErrCircuitOpen
+		if !errors.As(err, &circuitOpen) || circuitOpen.Name != "cache" {
+			t.Errorf("Expected *ErrCircuitOpen{Name:\"cache\"}, got %v", err)
+		}
+	})
+
+	t.Run("fallbackRunsInsteadOfErrCircuitOpen", func(t *testing.T) {
+		cb := NewCircuitBreakerFromSettings(Settings{
+			ResetTimeout: time.Hour,
+			ReadyToTrip:  func(c Counts) bool { return c.Failures >= 1 },
+			Fallback: func(ctx context.Context, err error) (interface{}, error) {
+				return "stale", nil
+			},
+		})
+		cb.RecordFailure()
+
+		result, err := cb.Execute(context.Background(), func(ctx context.Context) (interface{}, error) {
+			t.Fatal("req should not run while the breaker is open")
+			return nil, nil
+		})
+		if err != nil || result != "stale" {
+			t.Errorf("Expected fallback result (\"stale\", nil), got (%v, %v)", result, err)
+		}
+	})
+
+	t.Run("canceledContextReleasesHalfOpenSlotWithoutRecording", func(t *testing.T) {
+		cb := NewCircuitBreakerFromSettings(Settings{
+			ResetTimeout:        10 * time.Millisecond,
+			MaxHalfOpenRequests: 1,
+			ReadyToTrip:         func(c Counts) bool { return c.Failures >= 1 },
+		})
+		cb.RecordFailure()
+		time.Sleep(20 * time.Millisecond)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := cb.Execute(ctx, func(ctx context.Context) (interface{}, error) {
+			return nil, ctx.Err()
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected Execute to return context.Canceled, got %v", err)
+		}
+		if cb.GetState() != StateHalfOpen {
+			t.Fatalf("Expected a canceled trial to leave the breaker HALF-OPEN (not recorded as a failure), got %v", cb.GetState())
+		}
+
+		// Regression: before abandonHalfOpenTrial, a canceled half-open
+		// trial leaked its slot forever and every AllowRequest call below
+		// returned false permanently.
+		if !cb.AllowRequest() {
+			t.Fatal("Expected the half-open slot to be released after the canceled trial, not wedged shut")
+		}
+		cb.RecordSuccess()
+		if cb.GetState() != StateClosed {
+			t.Errorf("Expected state to be CLOSED after a successful trial following the canceled one, got %v", cb.GetState())
+		}
+	})
+}
@@ -0,0 +1,17 @@
+package circuitbreaker
+
+import "fmt"
+
+// ErrCircuitOpen is returned by Execute when the breaker denies a request
+// because it is OPEN (or HALF-OPEN with no trial slots available) and no
+// Fallback is configured.
+type ErrCircuitOpen struct {
+	Name string
+}
+
+func (e *ErrCircuitOpen) Error() string {
+	if e.Name == "" {
+		return "circuit breaker is open"
+	}
+	return fmt.Sprintf("circuit breaker %q is open", e.Name)
+}
@@ -0,0 +1,54 @@
+package circuitbreaker
+
+import "sync"
+
+// Registry lazily constructs and caches one CircuitBreaker per name, so
+// callers such as an API gateway's router can give each upstream/route its
+// own isolated breaker instead of sharing one global instance. Breakers are
+// built from whatever Settings the configured factory returns for that
+// name, on first access.
+type Registry struct {
+	mu       sync.Mutex
+	factory  func(name string) Settings
+	breakers map[string]*CircuitBreaker
+}
+
+// NewRegistry creates a Registry that builds a breaker's Settings on demand
+// via factory. factory is called at most once per distinct name.
+func NewRegistry(factory func(name string) Settings) *Registry {
+	return &Registry{
+		factory:  factory,
+		breakers: make(map[string]*CircuitBreaker),
+	}
+}
+
+// Get returns the breaker registered under name, constructing it from the
+// registry's factory on first access.
+func (r *Registry) Get(name string) *CircuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cb, ok := r.breakers[name]; ok {
+		return cb
+	}
+
+	settings := r.factory(name)
+	settings.Name = name
+	cb := NewCircuitBreakerFromSettings(settings)
+	r.breakers[name] = cb
+	return cb
+}
+
+// All returns a snapshot of every breaker constructed so far, keyed by
+// name, for callers such as metrics exporters and monitoring dashboards
+// that need to enumerate every known breaker.
+func (r *Registry) All() map[string]*CircuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := make(map[string]*CircuitBreaker, len(r.breakers))
+	for name, cb := range r.breakers {
+		snapshot[name] = cb
+	}
+	return snapshot
+}
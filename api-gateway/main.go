@@ -1,17 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
-	"net"
-	"io"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
@@ -20,8 +23,11 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/ulule/limiter/v3"
 	"github.com/ulule/limiter/v3/drivers/store/memory"
-	"github.com/sony/gobreaker"
-	"github.com/gorilla/websocket"
+
+	"github.com/JafarBanar/Scalable-API-Gateway-with-Circuit-Breaker-Pattern/api-gateway/adaptivelimit"
+	"github.com/JafarBanar/Scalable-API-Gateway-with-Circuit-Breaker-Pattern/api-gateway/circuitbreaker"
+	"github.com/JafarBanar/Scalable-API-Gateway-with-Circuit-Breaker-Pattern/api-gateway/monitor"
+	"github.com/JafarBanar/Scalable-API-Gateway-with-Circuit-Breaker-Pattern/api-gateway/proxy"
 )
 
 type Config struct {
@@ -37,6 +43,38 @@ type Response struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
+// cachedResponse is the last known-good response for a cache key, kept
+// around so the cache proxy's Fallback can serve stale data instead of
+// failing outright while the upstream is unavailable.
+type cachedResponse struct {
+	contentType string
+	body        []byte
+}
+
+var lastGoodCache sync.Map // map[string]cachedResponse, keyed by cache key
+
+// cacheResponse buffers a successful cache-read response so it can be
+// replayed both to the current client and, later, to lastGoodCache's
+// Fallback while the cache breaker is open. Buffering is safe here because
+// cached payloads are small JSON documents, not streamed content.
+func cacheResponse(resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	key := strings.TrimPrefix(resp.Request.URL.Path, "/cache/")
+	lastGoodCache.Store(key, cachedResponse{contentType: resp.Header.Get("Content-Type"), body: body})
+	return nil
+}
+
+func serveCachedResponse(w http.ResponseWriter, cached cachedResponse) {
+	w.Header().Set("Content-Type", cached.contentType)
+	w.Write(cached.body)
+}
+
 var (
 	logger = logrus.New()
 
@@ -57,19 +95,100 @@ var (
 		},
 		[]string{"method", "endpoint"},
 	)
+)
+
+var limiterInstance *limiter.Limiter
+var breakers *circuitbreaker.Registry
+var limiters *adaptivelimit.Registry
+var hub *monitor.Hub
+
+// breakerSettings holds the per-route circuit breaker configuration. Routes
+// not listed here fall back to defaultBreakerSettings.
+var breakerSettings = map[string]circuitbreaker.Settings{
+	"cache": {
+		ResetTimeout:                 10 * time.Second,
+		Window:                       10 * time.Second,
+		Buckets:                      10,
+		MaxHalfOpenRequests:          1,
+		RequiredConsecutiveSuccesses: 1,
+		ReadyToTrip: func(counts circuitbreaker.Counts) bool {
+			return counts.Failures >= 3
+		},
+	},
+	"example": {
+		ResetTimeout:                 5 * time.Second,
+		Window:                       30 * time.Second,
+		Buckets:                      6,
+		MaxHalfOpenRequests:          1,
+		RequiredConsecutiveSuccesses: 1,
+		ReadyToTrip: func(counts circuitbreaker.Counts) bool {
+			return counts.Total >= 10 && counts.SuccessRate < 0.5
+		},
+	},
+}
 
-	upgrader = websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			return true // Allow all origins for development
+func defaultBreakerSettings() circuitbreaker.Settings {
+	return circuitbreaker.Settings{
+		ResetTimeout:                 10 * time.Second,
+		Window:                       30 * time.Second,
+		Buckets:                      6,
+		MaxHalfOpenRequests:          1,
+		RequiredConsecutiveSuccesses: 1,
+		ReadyToTrip: func(counts circuitbreaker.Counts) bool {
+			return counts.Failures >= 5
 		},
 	}
-	clients = make(map[*websocket.Conn]bool)
-)
+}
 
-var limiterInstance *limiter.Limiter
-var cacheBreaker *gobreaker.CircuitBreaker
+// adaptiveLimitSettings holds the per-upstream AIMD concurrency limit
+// configuration. Upstreams not listed here fall back to
+// defaultAdaptiveLimitSettings.
+var adaptiveLimitSettings = map[string]adaptivelimit.Settings{
+	"cache": {
+		InitialLimit:      20,
+		MinLimit:          2,
+		MaxLimit:          50,
+		Beta:              0.9,
+		IncreaseEvery:     10,
+		BaselineAlpha:     0.2,
+		LatencyMultiplier: 2,
+	},
+	"example": {
+		InitialLimit:      10,
+		MinLimit:          2,
+		MaxLimit:          30,
+		Beta:              0.9,
+		IncreaseEvery:     10,
+		BaselineAlpha:     0.2,
+		LatencyMultiplier: 2,
+	},
+}
+
+func defaultAdaptiveLimitSettings() adaptivelimit.Settings {
+	return adaptivelimit.Settings{
+		InitialLimit:      10,
+		MinLimit:          2,
+		MaxLimit:          20,
+		Beta:              0.9,
+		IncreaseEvery:     10,
+		BaselineAlpha:     0.2,
+		LatencyMultiplier: 2,
+	}
+}
+
+// breakerNameForRoute maps a request path to the name of the breaker that
+// guards its upstream, so that /api/cache/*, /api/example, and future
+// upstream proxies each get isolated state.
+func breakerNameForRoute(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/api/cache"):
+		return "cache"
+	case strings.HasPrefix(path, "/api/example"):
+		return "example"
+	default:
+		return "default"
+	}
+}
 
 func init() {
 	// Initialize rate limiter
@@ -80,18 +199,29 @@ func init() {
 	store := memory.NewStore()
 	limiterInstance = limiter.New(store, rate)
 
-	cacheBreaker = gobreaker.NewCircuitBreaker(gobreaker.Settings{
-		Name:        "CacheService",
-		MaxRequests: 1, // Allow only one request in half-open state
-		Interval:    0,
-		Timeout:     10 * time.Second,
-		ReadyToTrip: func(counts gobreaker.Counts) bool {
-			return counts.ConsecutiveFailures >= 3
-		},
-		OnStateChange: func(name string, from, to gobreaker.State) {
+	breakers = circuitbreaker.NewRegistry(func(name string) circuitbreaker.Settings {
+		settings, ok := breakerSettings[name]
+		if !ok {
+			settings = defaultBreakerSettings()
+		}
+		settings.OnStateChange = func(name string, from, to circuitbreaker.CircuitBreakerState) {
 			logger.Printf("Circuit breaker '%s' state changed from %v to %v", name, from, to)
-			broadcastStateChange(from, to)
-		},
+			if hub != nil {
+				hub.OnStateChange(name, from, to)
+			}
+		}
+		return settings
+	})
+
+	hub = monitor.NewHub(breakers)
+	go hub.Run()
+
+	limiters = adaptivelimit.NewRegistry(func(name string) adaptivelimit.Settings {
+		settings, ok := adaptiveLimitSettings[name]
+		if !ok {
+			settings = defaultAdaptiveLimitSettings()
+		}
+		return settings
 	})
 }
 
@@ -176,6 +306,38 @@ func rateLimitMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// adaptiveLimitMiddleware admits requests to each upstream under its
+// adaptivelimit.Controller, shedding load with a 503 once the AIMD-derived
+// concurrency limit is reached instead of waiting for the breaker to trip.
+func adaptiveLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Skip local endpoints that don't call an upstream.
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" ||
+			r.URL.Path == "/monitor" || r.URL.Path == "/ws" ||
+			r.URL.Path == "/favicon.ico" || r.URL.Path == "/test-circuit" ||
+			r.URL.Path == "/" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		name := breakerNameForRoute(r.URL.Path)
+		controller := limiters.Get(name)
+
+		if !controller.AllowRequest() {
+			w.Header().Set("Retry-After", "1")
+			respondWithError(w, "Upstream overloaded, try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+		defer controller.Release()
+
+		start := time.Now()
+		next.ServeHTTP(w, r)
+
+		breakerOpen := breakers.Get(name).GetState() == circuitbreaker.StateOpen
+		controller.RecordLatency(time.Since(start), breakerOpen)
+	})
+}
+
 func metricsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -191,10 +353,10 @@ func authMiddleware(apiKey string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Skip auth for health check, metrics, monitoring, WebSocket, favicon, test endpoints, and root path
-			if r.URL.Path == "/health" || r.URL.Path == "/metrics" || 
-			   r.URL.Path == "/monitor" || r.URL.Path == "/ws" || 
-			   r.URL.Path == "/favicon.ico" || r.URL.Path == "/test-circuit" ||
-			   r.URL.Path == "/" {
+			if r.URL.Path == "/health" || r.URL.Path == "/metrics" ||
+				r.URL.Path == "/monitor" || r.URL.Path == "/ws" ||
+				r.URL.Path == "/favicon.ico" || r.URL.Path == "/test-circuit" ||
+				r.URL.Path == "/" {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -239,28 +401,11 @@ func respondWithJSON(w http.ResponseWriter, data interface{}, statusCode int) {
 	})
 }
 
-func broadcastStateChange(from, to gobreaker.State) {
-	logger.Printf("Broadcasting state change from %v to %v", from, to)
-	message := map[string]interface{}{
-		"type": "state_change",
-		"from": from.String(),
-		"to":   to.String(),
-		"time": time.Now().Format(time.RFC3339),
-	}
-	
-	logger.Printf("Number of connected clients: %d", len(clients))
-	for client := range clients {
-		logger.Printf("Sending state change to client")
-		err := client.WriteJSON(message)
-		if err != nil {
-			logger.Printf("Failed to send state change to client: %v", err)
-			client.Close()
-			delete(clients, client)
-		}
-	}
-}
-
 func setupRouter(config Config, limiter *limiter.Limiter) http.Handler {
+	cacheTarget, err := url.Parse(config.CacheServiceURL)
+	if err != nil {
+		logger.Fatalf("invalid cache service URL %q: %v", config.CacheServiceURL, err)
+	}
 	r := mux.NewRouter()
 
 	// Root path redirects to monitor dashboard
@@ -277,18 +422,17 @@ func setupRouter(config Config, limiter *limiter.Limiter) http.Handler {
 	r.HandleFunc("/test-circuit", func(w http.ResponseWriter, r *http.Request) {
 		// Simulate a sequence of state changes
 		go func() {
+			cacheBreaker := breakers.Get("cache")
+
 			// Initial state is CLOSED
 			logger.Printf("Starting circuit breaker test sequence")
 			time.Sleep(5 * time.Second) // 5 seconds before step 2
 
 			// Simulate failures to trigger OPEN state
 			for i := 0; i < 3; i++ {
-				_, err := cacheBreaker.Execute(func() (interface{}, error) {
-					logger.Printf("Simulated failure %d", i+1)
-					return nil, fmt.Errorf("simulated failure")
-				})
-				if err != nil {
+				if cacheBreaker.AllowRequest() {
 					logger.Printf("Simulated failure %d", i+1)
+					cacheBreaker.RecordFailure()
 				}
 				time.Sleep(1 * time.Second)
 			}
@@ -305,16 +449,13 @@ func setupRouter(config Config, limiter *limiter.Limiter) http.Handler {
 			logger.Printf("Attempting successful request...")
 			success := false
 			for i := 0; i < 5; i++ { // Try up to 5 times
-				result, err := cacheBreaker.Execute(func() (interface{}, error) {
+				if cacheBreaker.AllowRequest() {
 					logger.Printf("Executing success simulation attempt %d", i+1)
-					return "success", nil
-				})
-				if err == nil {
-					logger.Printf("Successfully simulated recovery: %v", result)
+					cacheBreaker.RecordSuccess()
 					success = true
 					break
 				}
-				logger.Printf("Failed to simulate success (attempt %d): %v", i+1, err)
+				logger.Printf("Failed to simulate success (attempt %d): breaker still open", i+1)
 				time.Sleep(1 * time.Second)
 			}
 
@@ -324,17 +465,12 @@ func setupRouter(config Config, limiter *limiter.Limiter) http.Handler {
 
 			// Verify final state
 			time.Sleep(2 * time.Second)
-			finalState := cacheBreaker.State()
+			finalState := cacheBreaker.GetState()
 			logger.Printf("Final circuit breaker state: %v", finalState)
-
-			// Force a state update to ensure the UI reflects the final state
-			if finalState == gobreaker.StateClosed {
-				broadcastStateChange(gobreaker.StateHalfOpen, gobreaker.StateClosed)
-			}
 		}()
 
 		respondWithJSON(w, map[string]string{
-			"status": "success",
+			"status":  "success",
 			"message": "Circuit breaker test sequence started. Watch the monitor dashboard for state changes.",
 		}, http.StatusOK)
 	}).Methods("GET")
@@ -349,91 +485,50 @@ func setupRouter(config Config, limiter *limiter.Limiter) http.Handler {
 
 	// API routes
 	api := r.PathPrefix("/api").Subrouter()
-	
+
 	// Example protected endpoint
 	api.HandleFunc("/example", func(w http.ResponseWriter, r *http.Request) {
-		respondWithJSON(w, map[string]string{"message": "This is a protected endpoint"}, http.StatusOK)
-	}).Methods("GET")
-
-	// Cache endpoints
-	api.HandleFunc("/cache/{key}", func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		key := vars["key"]
-
-		// Forward request to cache service
-		cacheURL := config.CacheServiceURL + "/cache/" + key
-		req, err := http.NewRequest("GET", cacheURL, nil)
-		if err != nil {
-			respondWithError(w, "Failed to create request", http.StatusInternalServerError)
-			return
-		}
-		result, err := cacheBreaker.Execute(func() (interface{}, error) {
-			return http.DefaultClient.Do(req)
-		})
-		if err != nil {
-			respondWithError(w, "Failed to get cache", http.StatusInternalServerError)
+		breaker := breakers.Get(breakerNameForRoute(r.URL.Path))
+		if !breaker.AllowRequest() {
+			respondWithError(w, "Service unavailable (circuit open)", http.StatusServiceUnavailable)
 			return
 		}
-		resp := result.(*http.Response)
-		defer resp.Body.Close()
-
-		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
-		w.WriteHeader(resp.StatusCode)
-		io.Copy(w, resp.Body)
+		breaker.RecordSuccess()
+		respondWithJSON(w, map[string]string{"message": "This is a protected endpoint"}, http.StatusOK)
 	}).Methods("GET")
 
-	api.HandleFunc("/cache", func(w http.ResponseWriter, r *http.Request) {
-		// Forward request to cache service
-		cacheURL := config.CacheServiceURL + "/cache"
-		result, err := cacheBreaker.Execute(func() (interface{}, error) {
-			return http.Post(cacheURL, "application/json", r.Body)
-		})
-		if err != nil {
-			respondWithError(w, "Failed to set cache", http.StatusInternalServerError)
-			return
-		}
-		resp := result.(*http.Response)
-		defer resp.Body.Close()
+	// Cache endpoints, proxied straight through to the cache service. Both
+	// share the "cache" breaker; only the keyed GET route keeps a stale
+	// fallback, since the POST route has no prior response to fall back to.
+	cacheReadProxy := proxy.New(proxy.Options{
+		Target:      cacheTarget,
+		StripPrefix: "/api",
+		Breakers:    breakers,
+		BreakerName: "cache",
+		OnResponse:  cacheResponse,
+		Fallback: func(w http.ResponseWriter, r *http.Request) {
+			key := mux.Vars(r)["key"]
+			if cached, ok := lastGoodCache.Load(key); ok {
+				serveCachedResponse(w, cached.(cachedResponse))
+				return
+			}
+			respondWithError(w, "Cache service unavailable (circuit open)", http.StatusServiceUnavailable)
+		},
+	})
+	api.Handle("/cache/{key}", cacheReadProxy).Methods("GET")
 
-		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
-		w.WriteHeader(resp.StatusCode)
-		io.Copy(w, resp.Body)
-	}).Methods("POST")
+	cacheWriteProxy := proxy.New(proxy.Options{
+		Target:      cacheTarget,
+		StripPrefix: "/api",
+		Breakers:    breakers,
+		BreakerName: "cache",
+	})
+	api.Handle("/cache", cacheWriteProxy).Methods("POST")
 
 	// Add WebSocket endpoint for monitoring
 	r.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		logger.Printf("New WebSocket connection request")
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			logger.Printf("Failed to upgrade connection: %v", err)
-			return
-		}
-		defer conn.Close()
-
-		logger.Printf("WebSocket connection established")
-		clients[conn] = true
-		defer delete(clients, conn)
-
-		// Send initial state
-		currentState := cacheBreaker.State().String()
-		logger.Printf("Sending initial state: %s", currentState)
-		err = conn.WriteJSON(map[string]interface{}{
-			"type": "initial_state",
-			"state": currentState,
-		})
-		if err != nil {
-			logger.Printf("Failed to send initial state: %v", err)
-			return
-		}
-
-		// Keep connection alive
-		for {
-			_, _, err := conn.ReadMessage()
-			if err != nil {
-				logger.Printf("WebSocket connection closed: %v", err)
-				break
-			}
-		}
+		hub.ServeWS(w, r)
 	})
 
 	// Add monitoring dashboard
@@ -443,8 +538,9 @@ func setupRouter(config Config, limiter *limiter.Limiter) http.Handler {
 
 	// Apply middleware in order
 	handler := metricsMiddleware(r)
+	handler = adaptiveLimitMiddleware(handler)
 	handler = rateLimitMiddleware(handler)
 	handler = authMiddleware(config.APIKey)(handler)
 
 	return handler
-} 
\ No newline at end of file
+}
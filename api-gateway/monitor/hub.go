@@ -0,0 +1,274 @@
+// Package monitor fans out circuit breaker state changes to WebSocket
+// clients and Prometheus, replacing the old package-level
+// map[*websocket.Conn]bool that main.go used to read and write from
+// multiple goroutines with no mutex.
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/JafarBanar/Scalable-API-Gateway-with-Circuit-Breaker-Pattern/api-gateway/circuitbreaker"
+)
+
+const (
+	writeWait     = 10 * time.Second
+	pongWait      = 60 * time.Second
+	pingPeriod    = (pongWait * 9) / 10
+	clientSendBuf = 16
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		return true // Allow all origins for development
+	},
+}
+
+var (
+	breakerStateGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "circuit_breaker_state",
+			Help: "Current state of each circuit breaker (0=closed, 1=open, 2=half-open)",
+		},
+		[]string{"name"},
+	)
+
+	breakerTransitionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_transitions_total",
+			Help: "Total number of circuit breaker state transitions",
+		},
+		[]string{"name", "from", "to"},
+	)
+)
+
+// subscribeRequest is the message a client sends to limit which breakers it
+// wants to hear about, e.g. {"subscribe":["cache","example"]}. An empty or
+// missing subscribe list means "everything".
+type subscribeRequest struct {
+	Subscribe []string `json:"subscribe"`
+}
+
+// stateChangeMessage is broadcast to subscribed clients whenever a breaker
+// transitions, and is also used (with Type "initial_state") to describe
+// every known breaker's state right after a client connects.
+type stateChangeMessage struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to"`
+	Time string `json:"time"`
+}
+
+// client is a single WebSocket connection and its subscription filter. It
+// is only ever read or written from the Hub's run loop, except for the
+// buffered send channel which is safe for concurrent use.
+type client struct {
+	conn          *websocket.Conn
+	send          chan []byte
+	subscriptions map[string]bool // empty means "subscribed to everything"
+}
+
+func (c *client) wants(name string) bool {
+	if len(c.subscriptions) == 0 {
+		return true
+	}
+	return c.subscriptions[name]
+}
+
+// subscribeUpdate is sent to the Hub's run loop by a client's readPump when
+// it receives a subscribe message.
+type subscribeUpdate struct {
+	client *client
+	names  []string
+}
+
+// Hub owns the set of connected monitor clients and fans out circuit
+// breaker state changes to them. All client-set mutation happens in Run,
+// so there is no shared-map data race between the register/unregister
+// goroutines and broadcasts.
+type Hub struct {
+	registry *circuitbreaker.Registry
+
+	clients    map[*client]bool
+	register   chan *client
+	unregister chan *client
+	broadcast  chan stateChangeMessage
+	subscribe  chan subscribeUpdate
+}
+
+// NewHub creates a Hub that reports on the breakers held by registry.
+func NewHub(registry *circuitbreaker.Registry) *Hub {
+	return &Hub{
+		registry:   registry,
+		clients:    make(map[*client]bool),
+		register:   make(chan *client),
+		unregister: make(chan *client),
+		broadcast:  make(chan stateChangeMessage),
+		subscribe:  make(chan subscribeUpdate),
+	}
+}
+
+// Run is the Hub's event loop. It must be started exactly once, typically
+// in its own goroutine, before ServeWS is called.
+func (h *Hub) Run() {
+	for {
+		select {
+		case c := <-h.register:
+			h.clients[c] = true
+		case c := <-h.unregister:
+			if _, ok := h.clients[c]; ok {
+				delete(h.clients, c)
+				close(c.send)
+			}
+		case upd := <-h.subscribe:
+			names := make(map[string]bool, len(upd.names))
+			for _, n := range upd.names {
+				names[n] = true
+			}
+			upd.client.subscriptions = names
+		case msg := <-h.broadcast:
+			payload, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			for c := range h.clients {
+				if !c.wants(msg.Name) {
+					continue
+				}
+				select {
+				case c.send <- payload:
+				default:
+					// Client isn't draining its send buffer fast enough;
+					// drop it rather than block the whole hub.
+					delete(h.clients, c)
+					close(c.send)
+				}
+			}
+		}
+	}
+}
+
+// OnStateChange records the transition as Prometheus metrics and broadcasts
+// it to subscribed WebSocket clients. It is the function to wire into
+// circuitbreaker.Settings.OnStateChange for every breaker in the registry.
+//
+// It is called synchronously from the breaker's own request-serving mutex
+// (CircuitBreaker.setState), so it must never block on the Hub: it tries a
+// non-blocking send to broadcast first and, if Run isn't ready to receive,
+// hands the send to a short-lived goroutine, so a stalled or panicking
+// Hub.Run can't freeze AllowRequest/RecordSuccess/RecordFailure across
+// every breaker in the registry.
+func (h *Hub) OnStateChange(name string, from, to circuitbreaker.CircuitBreakerState) {
+	breakerStateGauge.WithLabelValues(name).Set(float64(to))
+	breakerTransitionsTotal.WithLabelValues(name, from.String(), to.String()).Inc()
+
+	msg := stateChangeMessage{
+		Type: "state_change",
+		Name: name,
+		From: from.String(),
+		To:   to.String(),
+		Time: time.Now().Format(time.RFC3339),
+	}
+	select {
+	case h.broadcast <- msg:
+	default:
+		go func() { h.broadcast <- msg }()
+	}
+}
+
+// ServeWS upgrades the request to a WebSocket, sends an initial_state
+// snapshot of every breaker the registry has constructed so far, then pumps
+// messages until the connection closes.
+func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := &client{conn: conn, send: make(chan []byte, clientSendBuf)}
+	h.register <- c
+
+	for name, cb := range h.registry.All() {
+		state := cb.GetState()
+		breakerStateGauge.WithLabelValues(name).Set(float64(state))
+		payload, err := json.Marshal(stateChangeMessage{
+			Type: "initial_state",
+			Name: name,
+			To:   state.String(),
+			Time: time.Now().Format(time.RFC3339),
+		})
+		if err != nil {
+			continue
+		}
+		c.send <- payload
+	}
+
+	go c.writePump()
+	c.readPump(h)
+}
+
+// readPump reads subscribe requests (and discards everything else) until
+// the connection errors out, at which point it unregisters the client.
+// Every read resets the pong deadline, which is how dead peers that stop
+// responding to pings get detected and cleaned up.
+func (c *client) readPump(h *Hub) {
+	defer func() {
+		h.unregister <- c
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req subscribeRequest
+		if err := json.Unmarshal(message, &req); err == nil && req.Subscribe != nil {
+			h.subscribe <- subscribeUpdate{client: c, names: req.Subscribe}
+		}
+	}
+}
+
+// writePump drains the client's send channel and periodically pings the
+// connection so dead peers are detected even if they never send anything.
+func (c *client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"mime"
+	"net/http"
+)
+
+// IsStreamingContentType reports whether contentType looks like a
+// streaming response (SSE or gRPC) that should be flushed to the client as
+// it's written rather than buffered.
+func IsStreamingContentType(contentType string) bool {
+	base, _, _ := mime.ParseMediaType(contentType)
+	switch base {
+	case "text/event-stream", "application/grpc":
+		return true
+	}
+	return false
+}
+
+// flushingResponseWriter wraps a ResponseWriter so that once the upstream
+// response is seen to be streaming (by Content-Type, or by a missing
+// Content-Length, which is how a chunked upstream response arrives once its
+// Transfer-Encoding header has been stripped), every Write is immediately
+// flushed to the underlying connection instead of waiting to fill Go's
+// internal buffer.
+type flushingResponseWriter struct {
+	http.ResponseWriter
+	flusher   http.Flusher
+	streaming bool
+}
+
+func newFlushingResponseWriter(w http.ResponseWriter) *flushingResponseWriter {
+	flusher, _ := w.(http.Flusher)
+	return &flushingResponseWriter{ResponseWriter: w, flusher: flusher}
+}
+
+func (w *flushingResponseWriter) WriteHeader(statusCode int) {
+	h := w.Header()
+	w.streaming = IsStreamingContentType(h.Get("Content-Type")) ||
+		h.Get("Content-Length") == ""
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *flushingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	if w.streaming && w.flusher != nil {
+		w.flusher.Flush()
+	}
+	return n, err
+}
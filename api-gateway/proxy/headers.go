@@ -0,0 +1,34 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// hopByHopHeaders is the well-known set of headers that RFC 7230 section
+// 6.1 says apply only to a single transport-level connection and must not
+// be forwarded by a proxy.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// stripHopByHopHeaders removes the well-known hop-by-hop headers from h, as
+// well as any additional header named in h's own Connection header.
+func stripHopByHopHeaders(h http.Header) {
+	if conn := h.Get("Connection"); conn != "" {
+		for _, name := range strings.Split(conn, ",") {
+			h.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
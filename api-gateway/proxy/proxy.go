@@ -0,0 +1,181 @@
+// Package proxy forwards requests to an upstream service through
+// httputil.ReverseProxy, stripping hop-by-hop headers, flushing streaming
+// responses as they arrive, and tripping a circuit-breaker-registry
+// breaker on upstream failures instead of client cancellations.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/JafarBanar/Scalable-API-Gateway-with-Circuit-Breaker-Pattern/api-gateway/circuitbreaker"
+)
+
+// statusClientClosedRequest is nginx's de facto 499 status code, used when
+// the client cancels the request before the upstream (or the proxy) can
+// respond.
+const statusClientClosedRequest = 499
+
+// upstreamStatusError reports that the upstream itself answered with a 5xx
+// status, as opposed to a dial failure or timeout. modifyResponse records
+// one of these on the in-flight request's outcome instead of forwarding it
+// as an error to ReverseProxy, since the 5xx response should still be
+// streamed through to the client unchanged.
+type upstreamStatusError struct {
+	StatusCode int
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("upstream responded %d", e.StatusCode)
+}
+
+// outcomeKey is the context key under which ServeHTTP stashes a pointer to
+// the in-flight request's outcome, so modifyResponse and handleError (which
+// only get a *http.Request, not a return value) can report back what
+// happened for Execute to classify.
+type outcomeKey struct{}
+
+type outcome struct {
+	err error
+}
+
+// Options configures a Proxy.
+type Options struct {
+	// Target is the upstream base URL requests are forwarded to.
+	Target *url.URL
+
+	// StripPrefix is removed from the front of the incoming request path
+	// before it's joined onto Target's path, e.g. "/api" so that
+	// "/api/cache/foo" reaches the upstream as "/cache/foo".
+	StripPrefix string
+
+	// Breakers and BreakerName identify which breaker in the registry
+	// guards this upstream.
+	Breakers    *circuitbreaker.Registry
+	BreakerName string
+
+	// OnResponse, if set, is called with each successful upstream
+	// response after hop-by-hop headers are stripped but before its body
+	// is streamed to the client, e.g. to cache it for a later Fallback.
+	OnResponse func(resp *http.Response) error
+
+	// Fallback, if set, is invoked instead of a 503 when the breaker
+	// denies the request.
+	Fallback func(w http.ResponseWriter, r *http.Request)
+}
+
+// Proxy forwards requests to Options.Target under circuit-breaker
+// protection.
+type Proxy struct {
+	opts Options
+	rp   *httputil.ReverseProxy
+}
+
+// New creates a Proxy from opts.
+func New(opts Options) *Proxy {
+	p := &Proxy{opts: opts}
+
+	p.rp = &httputil.ReverseProxy{
+		Director:       p.director,
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.handleError,
+	}
+
+	return p
+}
+
+func (p *Proxy) director(req *http.Request) {
+	target := p.opts.Target
+
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	req.Host = target.Host
+	req.URL.Path = singleJoiningSlash(target.Path, strings.TrimPrefix(req.URL.Path, p.opts.StripPrefix))
+	req.URL.RawPath = ""
+
+	stripHopByHopHeaders(req.Header)
+
+	// X-Forwarded-For is appended automatically by ReverseProxy.ServeHTTP
+	// for Director-based proxies (we don't set Rewrite), so doing it here
+	// too would double the client IP.
+}
+
+// modifyResponse records a 5xx upstream response on the in-flight request's
+// outcome so ServeHTTP's Execute call sees it, but still returns nil so the
+// response itself is streamed through to the client unchanged rather than
+// replaced by handleError's generic error page.
+func (p *Proxy) modifyResponse(resp *http.Response) error {
+	stripHopByHopHeaders(resp.Header)
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		if o, ok := resp.Request.Context().Value(outcomeKey{}).(*outcome); ok {
+			o.err = &upstreamStatusError{StatusCode: resp.StatusCode}
+		}
+	}
+
+	if p.opts.OnResponse != nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return p.opts.OnResponse(resp)
+	}
+	return nil
+}
+
+// handleError is invoked for dial failures, timeouts, and any error
+// returned by modifyResponse. It records the error on the in-flight
+// request's outcome for Execute to classify, and writes the client-facing
+// response itself: a client-side cancel becomes 499, everything else a 502.
+func (p *Proxy) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	if o, ok := r.Context().Value(outcomeKey{}).(*outcome); ok {
+		o.err = err
+	}
+
+	if r.Context().Err() == context.Canceled {
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+
+	http.Error(w, "upstream request failed", http.StatusBadGateway)
+}
+
+// ServeHTTP denies the request with ErrCircuitOpen semantics (a 503, or
+// Fallback if configured) while the breaker is open, otherwise forwards it
+// upstream under Execute, which records the outcome modifyResponse/
+// handleError captured and skips recording entirely for a client cancel.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	breaker := p.opts.Breakers.Get(p.opts.BreakerName)
+	fw := newFlushingResponseWriter(w)
+
+	_, err := breaker.Execute(r.Context(), func(ctx context.Context) (interface{}, error) {
+		o := &outcome{}
+		req := r.WithContext(context.WithValue(ctx, outcomeKey{}, o))
+		p.rp.ServeHTTP(fw, req)
+		return nil, o.err
+	})
+
+	var circuitOpen *circuitbreaker.ErrCircuitOpen
+	if errors.As(err, &circuitOpen) {
+		if p.opts.Fallback != nil {
+			p.opts.Fallback(w, r)
+			return
+		}
+		http.Error(w, "upstream unavailable (circuit open)", http.StatusServiceUnavailable)
+	}
+}
+
+// singleJoiningSlash joins two URL path segments with exactly one slash
+// between them, mirroring httputil.NewSingleHostReverseProxy's helper.
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/JafarBanar/Scalable-API-Gateway-with-Circuit-Breaker-Pattern/api-gateway/circuitbreaker"
+)
+
+// TestProxyCanceledRequestDuringHalfOpenDoesNotWedgeBreaker reproduces the
+// regression where a client cancellation during a HALF-OPEN trial leaked
+// the trial slot forever (see CircuitBreaker.Execute's abandonHalfOpenTrial
+// path), which would have permanently 503'd every request behind this
+// Proxy after a single disconnect.
+func TestProxyCanceledRequestDuringHalfOpenDoesNotWedgeBreaker(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	target, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatalf("failed to parse upstream URL: %v", err)
+	}
+
+	breakers := circuitbreaker.NewRegistry(func(name string) circuitbreaker.Settings {
+		return circuitbreaker.Settings{
+			ResetTimeout:        10 * time.Millisecond,
+			MaxHalfOpenRequests: 1,
+			ReadyToTrip:         func(c circuitbreaker.Counts) bool { return c.Failures >= 1 },
+		}
+	})
+	p := New(Options{Target: target, Breakers: breakers, BreakerName: "upstream"})
+
+	breakers.Get("upstream").RecordFailure()
+	time.Sleep(20 * time.Millisecond) // let ResetTimeout elapse so the next request is a HALF-OPEN trial
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Code != statusClientClosedRequest {
+		t.Errorf("Expected a canceled request to get a %d, got %d", statusClientClosedRequest, rec.Code)
+	}
+
+	// Regression: before abandonHalfOpenTrial, this second request would
+	// have been denied forever with a 503 "circuit open".
+	req2 := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec2 := httptest.NewRecorder()
+	p.ServeHTTP(rec2, req2)
+	if rec2.Code != http.StatusOK {
+		t.Errorf("Expected the half-open slot to be released so a real request succeeds, got status %d", rec2.Code)
+	}
+}